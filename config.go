@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ZoneConfig describes a single Cloudflare zone the bouncer should manage
+// firewall rules for, and the action to take against IPs on the shared
+// account-level IP list within that zone. Action is the default applied to
+// any decision whose CrowdSec scenario isn't overridden by the top-level
+// Config.ScenarioActions.
+type ZoneConfig struct {
+	ZoneName   string `yaml:"zone_name"`
+	Action     string `yaml:"action"`
+	Expression string `yaml:"expression,omitempty"`
+}
+
+// Config holds the bouncer's configuration as loaded from its YAML file.
+type Config struct {
+	CloudflareAPIToken           string        `yaml:"cloudflare_api_token"`
+	CloudflareAccountID          string        `yaml:"cloudflare_account_id"`
+	CloudflareIPListName         string        `yaml:"cloudflare_ip_list_name"`
+	CloudflareUpdateFrequency    time.Duration `yaml:"cloudflare_update_frequency"`
+	CrowdSecLAPIKey              string        `yaml:"crowdsec_lapi_key"`
+	CrowdSecLAPIUrl              string        `yaml:"crowdsec_lapi_url"`
+	CrowdsecUpdateFrequencyYAML  time.Duration `yaml:"crowdsec_update_frequency"`
+	Daemon                       bool          `yaml:"daemon"`
+	Zones                        []ZoneConfig  `yaml:"zones"`
+	// Mode selects the Cloudflare API surface used to enforce the shared IP
+	// list: "firewall_rules" (the legacy, default API) or "rulesets" (the
+	// Rulesets Engine, for accounts that have disabled legacy firewall
+	// rules).
+	Mode string `yaml:"mode"`
+	// ListKinds selects which Cloudflare Lists the bouncer provisions and
+	// keeps in sync with CrowdSec decisions. "ip" mirrors Ip/Range-scoped
+	// decisions and is enforced via an "ip.src in $<list>" clause in every
+	// zone's default expression; "asn" mirrors AS-scoped decisions and is
+	// enforced the same way via "ip.src.asnum in $<list>". A zone that sets
+	// its own Expression is responsible for referencing whichever lists it
+	// cares about itself. "hostname" and "redirect" are provisioned on
+	// request but are never populated or enforced automatically, since no
+	// CrowdSec decision scope maps to them.
+	ListKinds []string `yaml:"list_kinds"`
+	// ScenarioActions overrides the action applied to a decision whose
+	// CrowdSec scenario (e.g. "crowdsecurity/http-probing") matches a key
+	// here, in every zone that uses the default expression, regardless of
+	// that zone's own Action. Decisions for an overridden scenario are
+	// mirrored into their own Cloudflare List per kind and action rather
+	// than the zone's default list, so a zone can enforce both its default
+	// Action and every action named here at once. A zone that sets its own
+	// Expression gets no override rules. Decisions whose scenario has no
+	// entry keep using the zone's Action as before.
+	ScenarioActions map[string]string `yaml:"scenario_actions,omitempty"`
+	// ReconcileInterval controls how often the bouncer fetches the full
+	// active CrowdSec decision set and the full Cloudflare list contents
+	// and converges any drift between them. Zero disables the periodic
+	// resync; the bouncer still reconciles once at startup regardless.
+	ReconcileInterval time.Duration `yaml:"reconcile_interval"`
+	// BulkOperationChunkSize caps how many items the bouncer sends to
+	// Cloudflare in a single list add/remove bulk operation.
+	BulkOperationChunkSize int `yaml:"bulk_operation_chunk_size"`
+	// PrometheusListenAddr, if set, serves Prometheus metrics (queue
+	// depth, bulk operation latency, 429 counts) on this address at
+	// /metrics.
+	PrometheusListenAddr string `yaml:"prometheus_listen_addr,omitempty"`
+}
+
+var validActions = map[string]bool{
+	"block":             true,
+	"challenge":         true,
+	"js_challenge":      true,
+	"managed_challenge": true,
+	"log":               true,
+}
+
+const (
+	modeFirewallRules = "firewall_rules"
+	modeRulesets      = "rulesets"
+)
+
+const (
+	listKindIP       = "ip"
+	listKindASN      = "asn"
+	listKindHostname = "hostname"
+	listKindRedirect = "redirect"
+)
+
+var validListKinds = map[string]bool{
+	listKindIP:       true,
+	listKindASN:      true,
+	listKindHostname: true,
+	listKindRedirect: true,
+}
+
+// NewConfig reads and validates the bouncer configuration from the file at
+// path.
+func NewConfig(path string) (*Config, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := &Config{
+		CloudflareIPListName:      "crowdsec",
+		CloudflareUpdateFrequency: 30 * time.Second,
+		Mode:                      modeFirewallRules,
+		ListKinds:                 []string{listKindIP},
+		ReconcileInterval:         time.Hour,
+		BulkOperationChunkSize:    1000,
+	}
+	if err := yaml.Unmarshal(content, conf); err != nil {
+		return nil, err
+	}
+
+	if conf.CloudflareAPIToken == "" {
+		return nil, errors.New("cloudflare_api_token is required")
+	}
+
+	if conf.CloudflareAccountID == "" {
+		return nil, errors.New("cloudflare_account_id is required")
+	}
+
+	if conf.Mode != modeFirewallRules && conf.Mode != modeRulesets {
+		return nil, errors.New("mode must be one of firewall_rules, rulesets")
+	}
+
+	if conf.BulkOperationChunkSize <= 0 {
+		return nil, errors.New("bulk_operation_chunk_size must be greater than zero")
+	}
+
+	if len(conf.ListKinds) == 0 {
+		return nil, errors.New("at least one kind must be configured under 'list_kinds'")
+	}
+
+	for _, kind := range conf.ListKinds {
+		if !validListKinds[kind] {
+			return nil, errors.New("list_kinds[] must be one of ip, asn, hostname, redirect")
+		}
+	}
+
+	for scenario, action := range conf.ScenarioActions {
+		if !validActions[action] {
+			return nil, fmt.Errorf("scenario_actions[%s] must be one of block, challenge, js_challenge, managed_challenge, log", scenario)
+		}
+	}
+
+	if len(conf.Zones) == 0 {
+		return nil, errors.New("at least one zone must be configured under 'zones'")
+	}
+
+	for _, zone := range conf.Zones {
+		if zone.ZoneName == "" {
+			return nil, errors.New("zones[].zone_name is required")
+		}
+		if !validActions[zone.Action] {
+			return nil, errors.New("zones[].action must be one of block, challenge, js_challenge, managed_challenge, log")
+		}
+	}
+
+	return conf, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("writing test config: %s", err)
+	}
+	return path
+}
+
+const validConfigYAML = `
+cloudflare_api_token: token
+cloudflare_account_id: account
+zones:
+  - zone_name: example.com
+    action: block
+`
+
+func TestNewConfigValid(t *testing.T) {
+	path := writeConfig(t, validConfigYAML)
+
+	conf, err := NewConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if conf.Mode != modeFirewallRules {
+		t.Errorf("Mode = %q, want default %q", conf.Mode, modeFirewallRules)
+	}
+	if len(conf.ListKinds) != 1 || conf.ListKinds[0] != listKindIP {
+		t.Errorf("ListKinds = %v, want default [%q]", conf.ListKinds, listKindIP)
+	}
+}
+
+func TestNewConfigValidation(t *testing.T) {
+	cases := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{
+			name:    "missing api token",
+			yaml:    `cloudflare_account_id: account` + "\nzones:\n  - zone_name: example.com\n    action: block\n",
+			wantErr: "cloudflare_api_token is required",
+		},
+		{
+			name:    "missing account id",
+			yaml:    `cloudflare_api_token: token` + "\nzones:\n  - zone_name: example.com\n    action: block\n",
+			wantErr: "cloudflare_account_id is required",
+		},
+		{
+			name: "bad mode",
+			yaml: `
+cloudflare_api_token: token
+cloudflare_account_id: account
+mode: bogus
+zones:
+  - zone_name: example.com
+    action: block
+`,
+			wantErr: "mode must be one of",
+		},
+		{
+			name: "bad chunk size",
+			yaml: `
+cloudflare_api_token: token
+cloudflare_account_id: account
+bulk_operation_chunk_size: 0
+zones:
+  - zone_name: example.com
+    action: block
+`,
+			wantErr: "bulk_operation_chunk_size must be greater than zero",
+		},
+		{
+			name: "bad list kind",
+			yaml: `
+cloudflare_api_token: token
+cloudflare_account_id: account
+list_kinds: ["bogus"]
+zones:
+  - zone_name: example.com
+    action: block
+`,
+			wantErr: "list_kinds[] must be one of",
+		},
+		{
+			name: "no zones",
+			yaml: `
+cloudflare_api_token: token
+cloudflare_account_id: account
+`,
+			wantErr: "at least one zone must be configured",
+		},
+		{
+			name: "zone missing name",
+			yaml: `
+cloudflare_api_token: token
+cloudflare_account_id: account
+zones:
+  - action: block
+`,
+			wantErr: "zones[].zone_name is required",
+		},
+		{
+			name: "zone bad action",
+			yaml: `
+cloudflare_api_token: token
+cloudflare_account_id: account
+zones:
+  - zone_name: example.com
+    action: bogus
+`,
+			wantErr: "zones[].action must be one of",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeConfig(t, tc.yaml)
+			_, err := NewConfig(path)
+			if err == nil {
+				t.Fatalf("expected an error, got none")
+			}
+			if !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("error = %q, want it to contain %q", err.Error(), tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewConfigMissingFile(t *testing.T) {
+	if _, err := NewConfig(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Fatal("expected an error for a missing config file")
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+const (
+	bulkOperationPollInterval = time.Second
+	bulkOperationPollTimeout  = 2 * time.Minute
+	defaultRetryAfter         = 30 * time.Second
+)
+
+// chunk splits items into slices of at most size, preserving order.
+func chunk[T any](items []T, size int) [][]T {
+	if size <= 0 {
+		size = len(items)
+	}
+	var chunks [][]T
+	for size < len(items) {
+		chunks = append(chunks, items[:size])
+		items = items[size:]
+	}
+	if len(items) > 0 {
+		chunks = append(chunks, items)
+	}
+	return chunks
+}
+
+// dispatchListMutations sends toAdd/toDelete to the given list in chunks of
+// at most chunkSize, polling each bulk operation to completion and
+// respecting Cloudflare's rate limiting before reporting queue depth and
+// latency metrics. addValues must be the same length as toAdd, giving the
+// decision value each entry in toAdd came from (the two are chunked
+// together). dispatchListMutations always returns the values and delete IDs
+// that were confirmed applied before any error, chunk by chunk, so a
+// mid-batch failure tells the caller exactly what still needs retrying
+// instead of nothing at all.
+func dispatchListMutations(ctx context.Context, cfAPI cloudflareAPI, kind string, listID string, toAdd []cloudflare.ListItemCreateRequest, addValues []string, toDelete []cloudflare.ListItemDeleteItemRequest, chunkSize int) (appliedAddValues []string, appliedDeleteIDs []string, err error) {
+	queueDepth.WithLabelValues(kind, "add").Set(float64(len(toAdd)))
+	queueDepth.WithLabelValues(kind, "delete").Set(float64(len(toDelete)))
+
+	addBatches := chunk(toAdd, chunkSize)
+	valueBatches := chunk(addValues, chunkSize)
+	for i, batch := range addBatches {
+		start := time.Now()
+		operationID, err := cfAPI.CreateListItemsAsync(ctx, listID, batch)
+		if err != nil {
+			if _, ok := retryAfterFromError(err); ok {
+				rateLimitedTotal.WithLabelValues(kind).Inc()
+			}
+			return appliedAddValues, appliedDeleteIDs, fmt.Errorf("submitting add batch of '%d' items: %w", len(batch), err)
+		}
+		if err := pollBulkOperation(ctx, cfAPI, kind, operationID); err != nil {
+			return appliedAddValues, appliedDeleteIDs, fmt.Errorf("waiting for add batch of '%d' items: %w", len(batch), err)
+		}
+		bulkOperationLatency.WithLabelValues(kind, "add").Observe(time.Since(start).Seconds())
+		appliedAddValues = append(appliedAddValues, valueBatches[i]...)
+	}
+
+	for _, batch := range chunk(toDelete, chunkSize) {
+		start := time.Now()
+		operationID, err := cfAPI.DeleteListItemsAsync(ctx, listID, cloudflare.ListItemDeleteRequest{Items: batch})
+		if err != nil {
+			if _, ok := retryAfterFromError(err); ok {
+				rateLimitedTotal.WithLabelValues(kind).Inc()
+			}
+			return appliedAddValues, appliedDeleteIDs, fmt.Errorf("submitting delete batch of '%d' items: %w", len(batch), err)
+		}
+		if err := pollBulkOperation(ctx, cfAPI, kind, operationID); err != nil {
+			return appliedAddValues, appliedDeleteIDs, fmt.Errorf("waiting for delete batch of '%d' items: %w", len(batch), err)
+		}
+		bulkOperationLatency.WithLabelValues(kind, "delete").Observe(time.Since(start).Seconds())
+		for _, item := range batch {
+			appliedDeleteIDs = append(appliedDeleteIDs, item.ID)
+		}
+	}
+
+	queueDepth.WithLabelValues(kind, "add").Set(0)
+	queueDepth.WithLabelValues(kind, "delete").Set(0)
+
+	return appliedAddValues, appliedDeleteIDs, nil
+}
+
+// pollBulkOperation polls a Cloudflare list bulk operation until it reaches
+// a terminal state, honoring Retry-After on 429s and giving up after
+// bulkOperationPollTimeout.
+func pollBulkOperation(ctx context.Context, cfAPI cloudflareAPI, kind string, operationID string) error {
+	deadline := time.Now().Add(bulkOperationPollTimeout)
+
+	for {
+		op, err := cfAPI.GetListBulkOperation(ctx, operationID)
+		if err != nil {
+			if retryAfter, ok := retryAfterFromError(err); ok {
+				rateLimitedTotal.WithLabelValues(kind).Inc()
+				if time.Now().Add(retryAfter).After(deadline) {
+					return fmt.Errorf("timed out waiting for bulk operation '%s' to complete", operationID)
+				}
+				time.Sleep(retryAfter)
+				continue
+			}
+			return err
+		}
+
+		switch op.Status {
+		case "completed":
+			return nil
+		case "failed":
+			return fmt.Errorf("bulk operation '%s' failed: %s", operationID, op.Error)
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for bulk operation '%s' to complete", operationID)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(bulkOperationPollInterval):
+		}
+	}
+}
+
+// retryAfterFromError reports whether err represents a 429 response and, if
+// so, how long to wait before retrying, taken from Cloudflare's Retry-After
+// header when present.
+func retryAfterFromError(err error) (time.Duration, bool) {
+	var cfErr *cloudflare.Error
+	if !errors.As(err, &cfErr) || cfErr.StatusCode != 429 {
+		return 0, false
+	}
+	if cfErr.RetryAfter > 0 {
+		return time.Duration(cfErr.RetryAfter) * time.Second, true
+	}
+	return defaultRetryAfter, true
+}
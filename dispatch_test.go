@@ -0,0 +1,31 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChunk(t *testing.T) {
+	cases := []struct {
+		name  string
+		items []int
+		size  int
+		want  [][]int
+	}{
+		{name: "empty", items: nil, size: 2, want: nil},
+		{name: "smaller than size", items: []int{1, 2}, size: 5, want: [][]int{{1, 2}}},
+		{name: "exact multiple", items: []int{1, 2, 3, 4}, size: 2, want: [][]int{{1, 2}, {3, 4}}},
+		{name: "remainder", items: []int{1, 2, 3, 4, 5}, size: 2, want: [][]int{{1, 2}, {3, 4}, {5}}},
+		{name: "size zero takes everything in one chunk", items: []int{1, 2, 3}, size: 0, want: [][]int{{1, 2, 3}}},
+		{name: "negative size takes everything in one chunk", items: []int{1, 2, 3}, size: -1, want: [][]int{{1, 2, 3}}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := chunk(tc.items, tc.size)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("chunk(%v, %d) = %v, want %v", tc.items, tc.size, got, tc.want)
+			}
+		})
+	}
+}
@@ -37,15 +37,34 @@ func HandleSignals(ctx context.Context) {
 }
 
 type cloudflareAPI interface {
-	CreateIPList(ctx context.Context, name string, desc string, typ string) (cloudflare.IPList, error)
-	DeleteIPList(ctx context.Context, id string) (cloudflare.IPListDeleteResponse, error)
-	ListIPLists(ctx context.Context) ([]cloudflare.IPList, error)
+	// List methods back the generic Lists API, which replaced the
+	// deprecated IPList* methods and additionally supports the "asn",
+	// "hostname" and "redirect" kinds alongside "ip".
+	CreateList(ctx context.Context, name string, desc string, kind string) (cloudflare.List, error)
+	DeleteList(ctx context.Context, id string) (cloudflare.ListDeleteResponse, error)
+	ListLists(ctx context.Context) ([]cloudflare.List, error)
+	// CreateListItemsAsync and DeleteListItemsAsync kick off a Cloudflare
+	// list bulk operation and return its operation ID; callers must poll
+	// GetListBulkOperation to learn the outcome.
+	CreateListItemsAsync(ctx context.Context, id string, items []cloudflare.ListItemCreateRequest) (string, error)
+	DeleteListItemsAsync(ctx context.Context, id string, items cloudflare.ListItemDeleteRequest) (string, error)
+	GetListBulkOperation(ctx context.Context, operationID string) (cloudflare.ListBulkOperation, error)
+	ListListItems(ctx context.Context, id string) ([]cloudflare.ListItem, error)
 	CreateFirewallRules(ctx context.Context, zone string, rules []cloudflare.FirewallRule) ([]cloudflare.FirewallRule, error)
 	DeleteFirewallRule(ctx context.Context, zone string, id string) error
 	DeleteFilter(ctx context.Context, zone string, id string) error
 	FirewallRules(ctx context.Context, zone string, opts cloudflare.PaginationOptions) ([]cloudflare.FirewallRule, error)
-	CreateIPListItems(ctx context.Context, id string, items []cloudflare.IPListItemCreateRequest) ([]cloudflare.IPListItem, error)
-	DeleteIPListItems(ctx context.Context, id string, items cloudflare.IPListItemDeleteRequest) ([]cloudflare.IPListItem, error)
+	// ZoneIDByName resolves a human-readable zone name (e.g. "example.com")
+	// to the Cloudflare zone ID used by the per-zone firewall rule calls.
+	ZoneIDByName(zoneName string) (string, error)
+
+	// Ruleset methods back the Rulesets Engine, the replacement for the
+	// legacy Firewall Rules API used when conf.Mode is "rulesets".
+	CreateRuleset(ctx context.Context, zone string, rs cloudflare.Ruleset) (cloudflare.Ruleset, error)
+	UpdateRuleset(ctx context.Context, zone string, rulesetID string, rs cloudflare.Ruleset) (cloudflare.Ruleset, error)
+	GetRuleset(ctx context.Context, zone string, rulesetID string) (cloudflare.Ruleset, error)
+	ListZoneRulesets(ctx context.Context, zone string) ([]cloudflare.Ruleset, error)
+	DeleteRuleset(ctx context.Context, zone string, rulesetID string) error
 }
 
 func main() {
@@ -70,7 +89,11 @@ func main() {
 		log.Fatal(err)
 	}
 
-	ipListID, err := setUpIPListAndFirewall(ctx, cfAPI, conf)
+	if err := SanityCheck(ctx, cfAPI, conf); err != nil {
+		log.Fatal(err)
+	}
+
+	listIDByKind, err := setUpIPListAndFirewall(ctx, cfAPI, conf)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -89,10 +112,37 @@ func main() {
 
 	go csLapi.Run()
 
-	cloudflareIDByIP := make(map[string]string)
-	// These maps are used to create slices without dup IPS
-	deleteIPMap := make(map[cloudflare.IPListItemDeleteItemRequest]bool)
-	addIPMap := make(map[cloudflare.IPListItemCreateRequest]bool)
+	if conf.PrometheusListenAddr != "" {
+		go ServeMetrics(conf.PrometheusListenAddr)
+	}
+
+	// cloudflareIDByValue, addItemMap and deleteItemMap are all keyed by
+	// routing key first (see actionRoutingKind), so that decisions scoped
+	// to IPs, ranges and ASNs - and any conf.ScenarioActions override among
+	// them - are mirrored into their own Cloudflare List independently.
+	cloudflareIDByValue := make(map[string]map[string]string)
+	deleteItemMap := make(map[string]map[string]bool)
+	addItemMap := make(map[string]map[pendingItem]bool)
+	backoffByKind := make(map[string]*backoffState)
+	for kind := range listIDByKind {
+		cloudflareIDByValue[kind] = make(map[string]string)
+		deleteItemMap[kind] = make(map[string]bool)
+		addItemMap[kind] = make(map[pendingItem]bool)
+		backoffByKind[kind] = &backoffState{}
+	}
+
+	// Rebuild cloudflareIDByValue from Cloudflare's authoritative state
+	// before reacting to any stream deltas, instead of assuming the lists
+	// start out empty.
+	if err := reconcile(ctx, cfAPI, conf, listIDByKind, cloudflareIDByValue); err != nil {
+		log.Errorf("initial reconciliation failed: %s", err)
+	}
+
+	var reconcileChan <-chan time.Time
+	if conf.ReconcileInterval > 0 {
+		reconcileTicker := time.NewTicker(conf.ReconcileInterval)
+		reconcileChan = reconcileTicker.C
+	}
 
 	t.Go(func() error {
 		for {
@@ -100,44 +150,93 @@ func main() {
 			case <-t.Dying():
 				return errors.New("tomb dying")
 
-			case <-cloudflareTicker.C:
-				addIPs := make([]cloudflare.IPListItemCreateRequest, 0)
-				deleteIPs := make([]cloudflare.IPListItemDeleteItemRequest, 0)
-				for k := range addIPMap {
-					addIPs = append(addIPs, k)
+			case <-reconcileChan:
+				if err := reconcile(ctx, cfAPI, conf, listIDByKind, cloudflareIDByValue); err != nil {
+					log.Errorf("periodic reconciliation failed: %s", err)
 				}
-				if len(addIPs) > 0 {
-					ipItems, err := cfAPI.CreateIPListItems(ctx, ipListID, addIPs)
-					log.Infof("making API call to cloudflare for adding '%d' decisions", len(addIPs))
 
-					if err != nil {
-						log.Fatal(err)
+			case <-cloudflareTicker.C:
+				for kind, listID := range listIDByKind {
+					if !backoffByKind[kind].ready() {
+						continue
 					}
 
-					for _, ipItem := range ipItems {
-						cloudflareIDByIP[ipItem.IP] = ipItem.ID
+					addItems := make([]cloudflare.ListItemCreateRequest, 0, len(addItemMap[kind]))
+					addValues := make([]string, 0, len(addItemMap[kind]))
+					for item := range addItemMap[kind] {
+						req, err := newListItemCreateRequest(baseListKind(kind), item)
+						if err != nil {
+							// A permanently invalid value (e.g. a
+							// non-numeric ASN) will never parse on a
+							// later retry either, so drop it here instead
+							// of re-logging it forever.
+							log.Errorf("dropping invalid '%s' decision: %s", kind, err)
+							delete(addItemMap[kind], item)
+							continue
+						}
+						addItems = append(addItems, req)
+						addValues = append(addValues, item.Value)
 					}
-				}
 
-				for k := range deleteIPMap {
-					deleteIPs = append(deleteIPs, k)
-				}
+					deleteIDs := make([]cloudflare.ListItemDeleteItemRequest, 0, len(deleteItemMap[kind]))
+					for id := range deleteItemMap[kind] {
+						deleteIDs = append(deleteIDs, cloudflare.ListItemDeleteItemRequest{ID: id})
+					}
+
+					if len(addItems) == 0 && len(deleteIDs) == 0 {
+						continue
+					}
+
+					log.Infof("dispatching '%d' adds and '%d' deletes to '%s' list", len(addItems), len(deleteIDs), kind)
+
+					appliedValues, appliedDeleteIDs, err := dispatchListMutations(ctx, cfAPI, kind, listID, addItems, addValues, deleteIDs, conf.BulkOperationChunkSize)
+
+					// Drop whatever was confirmed applied, chunk by chunk,
+					// from the pending maps before looking at err - a
+					// mid-batch failure must not leave already-applied
+					// items queued for resubmission next tick.
+					if len(appliedValues) > 0 {
+						currentByValue, refreshErr := listCurrentItems(ctx, cfAPI, listID)
+						if refreshErr != nil {
+							log.Errorf("failed to refresh '%s' list IDs after adding decisions: %s", kind, refreshErr)
+						} else {
+							for _, value := range appliedValues {
+								if id, ok := currentByValue[value]; ok {
+									cloudflareIDByValue[kind][value] = id
+								}
+							}
+						}
+						for item := range addItemMap[kind] {
+							for _, value := range appliedValues {
+								if item.Value == value {
+									delete(addItemMap[kind], item)
+								}
+							}
+						}
+					}
+					for _, id := range appliedDeleteIDs {
+						delete(deleteItemMap[kind], id)
+					}
 
-				if len(deleteIPs) > 0 {
-					_, err := cfAPI.DeleteIPListItems(ctx, ipListID, cloudflare.IPListItemDeleteRequest{Items: deleteIPs})
-					log.Infof("making API call to cloudflare to delete '%d' decisions", len(deleteIPs))
 					if err != nil {
-						log.Fatal(err)
+						if isFatalCloudflareError(err) {
+							log.Fatal(err)
+						}
+						log.Errorf("failed to sync '%s' list, will retry: %s", kind, err)
+						// Only the items dropped above were actually
+						// applied; everything still in addItemMap/
+						// deleteItemMap is retried next tick, after
+						// backoff.
+						backoffByKind[kind].recordFailure()
+						continue
 					}
-				}
 
-				// Flush
-				deleteIPMap = make(map[cloudflare.IPListItemDeleteItemRequest]bool)
-				addIPMap = make(map[cloudflare.IPListItemCreateRequest]bool)
+					backoffByKind[kind].reset()
+				}
 
 			case streamDecision := <-csLapi.Stream:
 				log.Printf("processing new and deleted decisions from crowdsec LAPI")
-				CollectLAPIStream(streamDecision, deleteIPMap, addIPMap, cloudflareIDByIP)
+				CollectLAPIStream(streamDecision, deleteItemMap, addItemMap, cloudflareIDByValue, conf.ScenarioActions)
 			}
 		}
 	})
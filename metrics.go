@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	queueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudflare_bouncer_queue_depth",
+		Help: "Number of pending add/delete decisions waiting to be sent to Cloudflare, by list kind and direction.",
+	}, []string{"kind", "direction"})
+
+	bulkOperationLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "cloudflare_bouncer_bulk_operation_duration_seconds",
+		Help:    "Time taken for a Cloudflare list bulk operation to reach a terminal state.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"kind", "direction"})
+
+	rateLimitedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cloudflare_bouncer_rate_limited_total",
+		Help: "Number of 429 responses received from the Cloudflare API, by list kind.",
+	}, []string{"kind"})
+)
+
+// ServeMetrics starts a blocking HTTP server exposing Prometheus metrics at
+// /metrics on addr. It's meant to be run in its own goroutine.
+func ServeMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	log.Infof("serving prometheus metrics on '%s'", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Errorf("prometheus metrics server stopped: %s", err)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/crowdsecurity/crowdsec/pkg/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// fetchActiveDecisions queries the CrowdSec LAPI's non-stream decisions
+// endpoint for the full set of currently active decisions, as opposed to
+// the incremental stream the bouncer otherwise consumes.
+func fetchActiveDecisions(conf *Config) ([]*models.Decision, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(conf.CrowdSecLAPIUrl, "/")+"/v1/decisions", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Api-Key", conf.CrowdSecLAPIKey)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("crowdsec LAPI returned status %d", resp.StatusCode)
+	}
+
+	var decisions []*models.Decision
+	if err := json.NewDecoder(resp.Body).Decode(&decisions); err != nil {
+		return nil, fmt.Errorf("decoding crowdsec LAPI response: %w", err)
+	}
+
+	return decisions, nil
+}
+
+// reconcile converges the Cloudflare List for each decision-managed routing
+// key (ip, asn, and any kind:action key conf.ScenarioActions routes
+// decisions to) with the full, authoritative set of active CrowdSec
+// decisions, rebuilding cloudflareIDByValue from Cloudflare's own state
+// rather than trusting whatever the bouncer has accumulated in memory.
+// Kinds with no CrowdSec decision source, such as hostname/redirect, are
+// left untouched.
+func reconcile(ctx context.Context, cfAPI cloudflareAPI, conf *Config, listIDByKind map[string]string, cloudflareIDByValue map[string]map[string]string) error {
+	decisions, err := fetchActiveDecisions(conf)
+	if err != nil {
+		return fmt.Errorf("while fetching active decisions from crowdsec LAPI: %w", err)
+	}
+
+	if len(decisions) == 0 {
+		// A momentary empty response (fresh LAPI, restarted database, a key
+		// that can't see any decisions yet) must not be treated as "ban
+		// nothing" - that would delete every item already on the
+		// Cloudflare lists. Skip this reconciliation pass and let the next
+		// one pick up real state once the LAPI has decisions to report.
+		log.Warnf("crowdsec LAPI returned no active decisions, skipping reconciliation to avoid wiping existing lists")
+		return nil
+	}
+
+	// Only routing keys built on a decision-managed kind (see scopeToListKind)
+	// have an authoritative "desired" set here; "hostname"/"redirect" lists
+	// are operator-managed and must never be touched by reconciliation, or
+	// every pass would delete everything in them.
+	desiredByKind := make(map[string]map[string]bool)
+	for kind := range listIDByKind {
+		if !decisionManagedKinds[baseListKind(kind)] {
+			continue
+		}
+		desiredByKind[kind] = make(map[string]bool)
+	}
+	for _, decision := range decisions {
+		if decision.Value == nil || decision.Scope == nil {
+			continue
+		}
+		kind, ok := scopeToListKind(*decision.Scope)
+		if !ok {
+			continue
+		}
+		kind = actionRoutingKind(kind, decision.Scenario, conf.ScenarioActions)
+		if _, managed := desiredByKind[kind]; !managed {
+			continue
+		}
+		desiredByKind[kind][*decision.Value] = true
+	}
+
+	for kind, listID := range listIDByKind {
+		if _, managed := desiredByKind[kind]; !managed {
+			continue
+		}
+
+		currentByValue, err := listCurrentItems(ctx, cfAPI, listID)
+		if err != nil {
+			return fmt.Errorf("while listing current '%s' list items: %w", kind, err)
+		}
+
+		var toAdd []cloudflare.ListItemCreateRequest
+		var toAddValues []string
+		var toDelete []cloudflare.ListItemDeleteItemRequest
+
+		for value := range desiredByKind[kind] {
+			if _, present := currentByValue[value]; !present {
+				item, err := newListItemCreateRequest(baseListKind(kind), pendingItem{Value: value, Comment: "banned by crowdsec"})
+				if err != nil {
+					log.Errorf("skipping invalid '%s' decision during reconciliation: %s", kind, err)
+					continue
+				}
+				toAdd = append(toAdd, item)
+				toAddValues = append(toAddValues, value)
+			}
+		}
+
+		for value, id := range currentByValue {
+			if !desiredByKind[kind][value] {
+				toDelete = append(toDelete, cloudflare.ListItemDeleteItemRequest{ID: id})
+			}
+		}
+
+		if len(toAdd) > 0 || len(toDelete) > 0 {
+			_, _, dispatchErr := dispatchListMutations(ctx, cfAPI, kind, listID, toAdd, toAddValues, toDelete, conf.BulkOperationChunkSize)
+
+			// Re-read Cloudflare's own state even on a mid-batch failure,
+			// so cloudflareIDByValue reflects whatever was actually
+			// applied instead of staying stale until the next successful
+			// reconciliation.
+			var refreshErr error
+			currentByValue, refreshErr = listCurrentItems(ctx, cfAPI, listID)
+			if refreshErr != nil {
+				return fmt.Errorf("while refreshing '%s' list items after reconciliation: %w", kind, refreshErr)
+			}
+
+			if dispatchErr != nil {
+				cloudflareIDByValue[kind] = currentByValue
+				return fmt.Errorf("while converging '%s' list during reconciliation: %w", kind, dispatchErr)
+			}
+		}
+
+		cloudflareIDByValue[kind] = currentByValue
+		log.Infof("reconciled '%s' list: %d added, %d removed, %d in sync", kind, len(toAdd), len(toDelete), len(currentByValue))
+	}
+
+	return nil
+}
+
+func listCurrentItems(ctx context.Context, cfAPI cloudflareAPI, listID string) (map[string]string, error) {
+	items, err := cfAPI.ListListItems(ctx, listID)
+	if err != nil {
+		return nil, err
+	}
+
+	byValue := make(map[string]string, len(items))
+	for _, item := range items {
+		if value, ok := listItemValue(item); ok {
+			byValue[value] = item.ID
+		}
+	}
+
+	return byValue, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+const (
+	initialBackoff = time.Second
+	maxBackoff     = 5 * time.Minute
+)
+
+// backoffState tracks exponential backoff for a single kind's Cloudflare
+// List mutations, so a transient failure doesn't get retried every tick.
+type backoffState struct {
+	delay       time.Duration
+	nextAttempt time.Time
+}
+
+func (b *backoffState) ready() bool {
+	return b.nextAttempt.IsZero() || time.Now().After(b.nextAttempt)
+}
+
+func (b *backoffState) recordFailure() {
+	if b.delay == 0 {
+		b.delay = initialBackoff
+	} else {
+		b.delay *= 2
+		if b.delay > maxBackoff {
+			b.delay = maxBackoff
+		}
+	}
+	b.nextAttempt = time.Now().Add(b.delay)
+}
+
+func (b *backoffState) reset() {
+	b.delay = 0
+	b.nextAttempt = time.Time{}
+}
+
+// isFatalCloudflareError reports whether err represents an authentication or
+// authorization failure (401/403), which retrying cannot fix, as opposed to
+// a transient error (rate limiting, 5xx, network) that's worth re-queueing.
+func isFatalCloudflareError(err error) bool {
+	var cfErr *cloudflare.Error
+	if errors.As(err, &cfErr) {
+		return cfErr.StatusCode == 401 || cfErr.StatusCode == 403
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+func TestBackoffState(t *testing.T) {
+	var b backoffState
+
+	if !b.ready() {
+		t.Fatal("a fresh backoffState should be ready immediately")
+	}
+
+	b.recordFailure()
+	if b.ready() {
+		t.Fatal("backoffState should not be ready right after a recorded failure")
+	}
+	if b.delay != initialBackoff {
+		t.Fatalf("delay = %s, want %s after first failure", b.delay, initialBackoff)
+	}
+
+	b.recordFailure()
+	if b.delay != 2*initialBackoff {
+		t.Fatalf("delay = %s, want %s after second failure", b.delay, 2*initialBackoff)
+	}
+
+	for i := 0; i < 20; i++ {
+		b.recordFailure()
+	}
+	if b.delay != maxBackoff {
+		t.Fatalf("delay = %s, want it capped at %s", b.delay, maxBackoff)
+	}
+
+	b.reset()
+	if b.delay != 0 || !b.nextAttempt.IsZero() || !b.ready() {
+		t.Fatal("reset should clear delay, nextAttempt and make the state ready again")
+	}
+}
+
+func TestBackoffStateReadyAfterDelayElapses(t *testing.T) {
+	b := backoffState{delay: time.Millisecond, nextAttempt: time.Now().Add(-time.Millisecond)}
+	if !b.ready() {
+		t.Fatal("backoffState should be ready once nextAttempt is in the past")
+	}
+}
+
+func TestIsFatalCloudflareError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil error", err: nil, want: false},
+		{name: "unrelated error", err: errors.New("boom"), want: false},
+		{name: "401", err: &cloudflare.Error{StatusCode: 401}, want: true},
+		{name: "403", err: &cloudflare.Error{StatusCode: 403}, want: true},
+		{name: "429 is not fatal", err: &cloudflare.Error{StatusCode: 429}, want: false},
+		{name: "500 is not fatal", err: &cloudflare.Error{StatusCode: 500}, want: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isFatalCloudflareError(tc.err); got != tc.want {
+				t.Errorf("isFatalCloudflareError(%v) = %v, want %v", tc.err, got, tc.want)
+			}
+		})
+	}
+}
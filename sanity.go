@@ -0,0 +1,23 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// SanityCheck validates that the configured Cloudflare API token and account
+// ID work, and that every configured zone is resolvable and accessible with
+// that token, before the bouncer commits to the main loop.
+func SanityCheck(ctx context.Context, cfAPI cloudflareAPI, conf *Config) error {
+	if _, err := cfAPI.ListLists(ctx); err != nil {
+		return fmt.Errorf("cloudflare API token or account ID is invalid: %w", err)
+	}
+
+	for _, zone := range conf.Zones {
+		if _, err := cfAPI.ZoneIDByName(zone.ZoneName); err != nil {
+			return fmt.Errorf("zone '%s' is not accessible with the configured token: %w", zone.ZoneName, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/crowdsecurity/crowdsec/pkg/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// firewallCustomPhase is the Rulesets Engine phase that corresponds to the
+// legacy "firewall rules" entry point.
+const firewallCustomPhase = "http_request_firewall_custom"
+
+// crowdsecRuleRef tags the ruleset rule enforcing a zone's default action,
+// so it can be found and updated without disturbing rules the customer
+// manages themselves. A rule enforcing a conf.ScenarioActions override is
+// tagged with overrideRuleRef(action) instead.
+const crowdsecRuleRef = "crowdsec"
+
+// overrideRuleRef tags the ruleset rule (or, via
+// crowdsecRuleDescriptionPrefix, the legacy Firewall Rule) enforcing a
+// conf.ScenarioActions override for action, kept distinct per action so a
+// zone can carry its default rule and every override rule side by side.
+func overrideRuleRef(action string) string {
+	return crowdsecRuleRef + "_" + action
+}
+
+// decisionManagedKinds are the list kinds a CrowdSec decision scope can
+// resolve to (see scopeToListKind). Every other configured kind
+// (hostname/redirect) is provisioned but never populated or enforced
+// automatically.
+var decisionManagedKinds = map[string]bool{
+	listKindIP:  true,
+	listKindASN: true,
+}
+
+// baseListKind strips a conf.ScenarioActions routing suffix ("ip:challenge")
+// down to the underlying Cloudflare List kind ("ip"), for code that only
+// cares about the kind itself (building a List item payload, for example).
+func baseListKind(routingKind string) string {
+	if i := strings.IndexByte(routingKind, ':'); i >= 0 {
+		return routingKind[:i]
+	}
+	return routingKind
+}
+
+// actionRoutingKind returns the key under which a decision for the given
+// base kind is tracked: the bare kind, enforced by the zone's own Action,
+// unless the decision's CrowdSec scenario is overridden by scenarioActions,
+// in which case it's routed to its own kind+action key instead.
+func actionRoutingKind(kind string, scenario *string, scenarioActions map[string]string) string {
+	if scenario == nil || len(scenarioActions) == 0 {
+		return kind
+	}
+	if action, ok := scenarioActions[*scenario]; ok {
+		return kind + ":" + action
+	}
+	return kind
+}
+
+// distinctSortedActions returns the distinct action values configured in
+// scenarioActions, sorted for deterministic list/rule provisioning order.
+func distinctSortedActions(scenarioActions map[string]string) []string {
+	seen := make(map[string]bool, len(scenarioActions))
+	actions := make([]string, 0, len(scenarioActions))
+	for _, action := range scenarioActions {
+		if !seen[action] {
+			seen[action] = true
+			actions = append(actions, action)
+		}
+	}
+	sort.Strings(actions)
+	return actions
+}
+
+// routingListName derives the Cloudflare List name for a routing key,
+// reusing listName's "ip" special case for an unrouted key and appending
+// the action for a conf.ScenarioActions-routed key.
+func routingListName(base, routingKind string) string {
+	kind := baseListKind(routingKind)
+	if kind == routingKind {
+		return listName(base, kind)
+	}
+	return fmt.Sprintf("%s_%s", listName(base, kind), routingKind[len(kind)+1:])
+}
+
+// setUpIPListAndFirewall provisions one Cloudflare List per kind configured
+// in conf.ListKinds, plus one additional List per (decision-managed kind,
+// action) pair named in conf.ScenarioActions, then enforces them in each
+// configured zone using either the legacy Firewall Rules API or the
+// Rulesets Engine, depending on conf.Mode. It returns the list ID for each
+// kind (or kind:action routing key), keyed accordingly.
+func setUpIPListAndFirewall(ctx context.Context, cfAPI cloudflareAPI, conf *Config) (map[string]string, error) {
+	overrideActions := distinctSortedActions(conf.ScenarioActions)
+
+	var routingKinds []string
+	for _, kind := range conf.ListKinds {
+		routingKinds = append(routingKinds, kind)
+		if decisionManagedKinds[kind] {
+			for _, action := range overrideActions {
+				routingKinds = append(routingKinds, kind+":"+action)
+			}
+		}
+	}
+
+	listIDByKind := make(map[string]string)
+	for _, routingKind := range routingKinds {
+		kind := baseListKind(routingKind)
+		id, err := findOrCreateList(ctx, cfAPI, routingListName(conf.CloudflareIPListName, routingKind), kind)
+		if err != nil {
+			return nil, fmt.Errorf("while setting up '%s' list: %w", routingKind, err)
+		}
+		listIDByKind[routingKind] = id
+	}
+
+	if _, ok := listIDByKind[listKindIP]; !ok {
+		return nil, fmt.Errorf("list_kinds must include 'ip' so firewall rules can reference it")
+	}
+
+	for _, zone := range conf.Zones {
+		zoneID, err := cfAPI.ZoneIDByName(zone.ZoneName)
+		if err != nil {
+			return nil, fmt.Errorf("while resolving zone '%s': %w", zone.ZoneName, err)
+		}
+
+		rules := zoneActionRules(conf, zone, overrideActions)
+
+		for _, r := range rules {
+			switch conf.Mode {
+			case modeRulesets:
+				if err := setUpZoneRuleset(ctx, cfAPI, zoneID, r.ruleRef, r.action, r.expression); err != nil {
+					return nil, fmt.Errorf("while setting up ruleset for zone '%s': %w", zone.ZoneName, err)
+				}
+			default:
+				if err := setUpZoneFirewallRule(ctx, cfAPI, zoneID, r.ruleRef, r.action, r.expression); err != nil {
+					return nil, fmt.Errorf("while setting up firewall rule for zone '%s': %w", zone.ZoneName, err)
+				}
+			}
+		}
+
+		log.Infof("set up %d rule(s) for zone '%s' (mode=%s)", len(rules), zone.ZoneName, conf.Mode)
+	}
+
+	return listIDByKind, nil
+}
+
+// zoneActionRule is one firewall rule (legacy or ruleset) a zone needs: the
+// zone's default Action enforcing its default lists, or a
+// conf.ScenarioActions override enforcing that action's own lists.
+type zoneActionRule struct {
+	ruleRef    string
+	action     string
+	expression string
+}
+
+// zoneActionRules computes the rules a zone needs to enforce its default
+// Action plus every conf.ScenarioActions override. A zone with its own
+// Expression is responsible for referencing whichever lists it cares about
+// itself, so it gets a single rule and no overrides are added on top of it.
+func zoneActionRules(conf *Config, zone ZoneConfig, overrideActions []string) []zoneActionRule {
+	if zone.Expression != "" {
+		return []zoneActionRule{{ruleRef: crowdsecRuleRef, action: zone.Action, expression: zone.Expression}}
+	}
+
+	rules := []zoneActionRule{{ruleRef: crowdsecRuleRef, action: zone.Action, expression: zoneExpressionForAction(conf, "")}}
+	for _, action := range overrideActions {
+		rules = append(rules, zoneActionRule{
+			ruleRef:    overrideRuleRef(action),
+			action:     action,
+			expression: zoneExpressionForAction(conf, action),
+		})
+	}
+	return rules
+}
+
+// zoneExpressionForAction builds the expression enforcing every List kind
+// populated for the given action: action == "" is a zone's own default
+// Action, matching decisions with no conf.ScenarioActions override; any
+// other action matches only decisions whose CrowdSec scenario was routed to
+// it. "hostname" and "redirect" are left out: nothing ever populates them
+// automatically, so a rule referencing them would never match.
+func zoneExpressionForAction(conf *Config, action string) string {
+	var clauses []string
+	for _, kind := range conf.ListKinds {
+		if !decisionManagedKinds[kind] {
+			continue
+		}
+
+		routingKind := kind
+		if action != "" {
+			routingKind = kind + ":" + action
+		}
+		name := routingListName(conf.CloudflareIPListName, routingKind)
+
+		if kind == listKindASN {
+			clauses = append(clauses, fmt.Sprintf("ip.src.asnum in $%s", name))
+		} else {
+			clauses = append(clauses, fmt.Sprintf("ip.src in $%s", name))
+		}
+	}
+	return strings.Join(clauses, " or ")
+}
+
+// crowdsecRuleDescriptionPrefix tags a legacy Firewall Rule the bouncer owns
+// in a zone, so repeated runs can find and reuse it instead of appending a
+// fresh duplicate on every restart. ruleRef (crowdsecRuleRef or an
+// overrideRuleRef) keeps a zone's default rule and its override rules
+// distinct from one another.
+func crowdsecRuleDescriptionPrefix(ruleRef string) string {
+	return ruleRef + ": "
+}
+
+// setUpZoneFirewallRule creates the zone's legacy Firewall Rule for ruleRef
+// enforcing expression with action, or leaves it alone if a rule with the
+// expected description and expression already exists. A stale rule for the
+// same ruleRef (e.g. left over from a config change) is replaced rather
+// than accumulated alongside, mirroring the idempotence findOrCreateList
+// gives the underlying list. Rules belonging to a different ruleRef, such
+// as another conf.ScenarioActions override, are left untouched.
+func setUpZoneFirewallRule(ctx context.Context, cfAPI cloudflareAPI, zoneID string, ruleRef string, action string, expression string) error {
+	prefix := crowdsecRuleDescriptionPrefix(ruleRef)
+	description := prefix + action
+
+	existingRules, err := cfAPI.FirewallRules(ctx, zoneID, cloudflare.PaginationOptions{})
+	if err != nil {
+		return err
+	}
+
+	for _, existing := range existingRules {
+		if !strings.HasPrefix(existing.Description, prefix) {
+			continue
+		}
+
+		if existing.Description == description && existing.Filter.Expression == expression {
+			return nil
+		}
+
+		if err := cfAPI.DeleteFirewallRule(ctx, zoneID, existing.ID); err != nil {
+			return fmt.Errorf("while removing stale crowdsec firewall rule: %w", err)
+		}
+		if existing.Filter.ID != "" {
+			if err := cfAPI.DeleteFilter(ctx, zoneID, existing.Filter.ID); err != nil {
+				return fmt.Errorf("while removing stale crowdsec filter: %w", err)
+			}
+		}
+	}
+
+	rule := cloudflare.FirewallRule{
+		Filter: cloudflare.Filter{
+			Expression: expression,
+		},
+		Action:      action,
+		Description: description,
+	}
+
+	_, err = cfAPI.CreateFirewallRules(ctx, zoneID, []cloudflare.FirewallRule{rule})
+	return err
+}
+
+// setUpZoneRuleset creates or updates the zone's custom firewall ruleset so
+// that it contains a rule, identified by ruleRef, enforcing expression with
+// action. Only the rule matching ruleRef is added or replaced; any other
+// rules already present in the ruleset, including the bouncer's own rules
+// for other ruleRefs, are left untouched.
+func setUpZoneRuleset(ctx context.Context, cfAPI cloudflareAPI, zoneID string, ruleRef string, action string, expression string) error {
+	rule := cloudflare.RulesetRule{
+		Ref:         ruleRef,
+		Expression:  expression,
+		Action:      action,
+		Description: ruleRef + ": " + action,
+	}
+
+	rulesets, err := cfAPI.ListZoneRulesets(ctx, zoneID)
+	if err != nil {
+		return err
+	}
+
+	for _, existing := range rulesets {
+		if existing.Phase != firewallCustomPhase {
+			continue
+		}
+
+		// ListZoneRulesets only returns ruleset metadata; Rules comes back
+		// empty. The full rule set must be fetched per-ruleset, or
+		// replaceCrowdsecRule would append onto a nil slice and
+		// UpdateRuleset would replace the zone's entire custom ruleset
+		// with just this rule.
+		full, err := cfAPI.GetRuleset(ctx, zoneID, existing.ID)
+		if err != nil {
+			return err
+		}
+
+		full.Rules = replaceCrowdsecRule(full.Rules, rule)
+		_, err = cfAPI.UpdateRuleset(ctx, zoneID, full.ID, full)
+		return err
+	}
+
+	newRuleset := cloudflare.Ruleset{
+		Name:        "crowdsec",
+		Description: "managed by the CrowdSec Cloudflare bouncer",
+		Kind:        "zone",
+		Phase:       firewallCustomPhase,
+		Rules:       []cloudflare.RulesetRule{rule},
+	}
+
+	_, err = cfAPI.CreateRuleset(ctx, zoneID, newRuleset)
+	return err
+}
+
+// replaceCrowdsecRule returns rules with the rule matching updated's Ref
+// replaced by updated, or updated appended if the ruleset doesn't have one
+// yet. All other rules, including ones for a different Ref the bouncer
+// itself owns, are preserved in place.
+func replaceCrowdsecRule(rules []cloudflare.RulesetRule, updated cloudflare.RulesetRule) []cloudflare.RulesetRule {
+	for i, existing := range rules {
+		if existing.Ref == updated.Ref {
+			rules[i] = updated
+			return rules
+		}
+	}
+	return append(rules, updated)
+}
+
+// listName derives the name of the Cloudflare List that backs a given kind.
+// The "ip" kind keeps the bare configured name for backwards compatibility
+// with deployments that reference it from a hand-written zone expression.
+func listName(base, kind string) string {
+	if kind == listKindIP {
+		return base
+	}
+	return base + "_" + kind
+}
+
+func findOrCreateList(ctx context.Context, cfAPI cloudflareAPI, name string, kind string) (string, error) {
+	lists, err := cfAPI.ListLists(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	for _, list := range lists {
+		if list.Name == name {
+			return list.ID, nil
+		}
+	}
+
+	list, err := cfAPI.CreateList(ctx, name, "managed by the CrowdSec Cloudflare bouncer", kind)
+	if err != nil {
+		return "", err
+	}
+
+	return list.ID, nil
+}
+
+// scopeToListKind maps a CrowdSec decision scope to the Cloudflare List kind
+// it should be mirrored into. Country-scoped decisions have no equivalent
+// List kind and are reported as unsupported.
+func scopeToListKind(scope string) (string, bool) {
+	switch scope {
+	case "Ip", "Range":
+		return listKindIP, true
+	case "AS":
+		return listKindASN, true
+	default:
+		return "", false
+	}
+}
+
+// pendingItem is a decision value queued for addition to a Cloudflare List,
+// deduplicated by value regardless of kind.
+type pendingItem struct {
+	Value   string
+	Comment string
+}
+
+// newListItemCreateRequest builds the kind-specific Cloudflare List item
+// payload for a pending value.
+func newListItemCreateRequest(kind string, item pendingItem) (cloudflare.ListItemCreateRequest, error) {
+	req := cloudflare.ListItemCreateRequest{Comment: item.Comment}
+	switch kind {
+	case listKindIP:
+		req.IP = &item.Value
+	case listKindASN:
+		asn, err := strconv.Atoi(item.Value)
+		if err != nil {
+			return req, fmt.Errorf("invalid ASN '%s': %w", item.Value, err)
+		}
+		req.ASN = &asn
+	default:
+		return req, fmt.Errorf("kind '%s' cannot be populated from a CrowdSec decision", kind)
+	}
+	return req, nil
+}
+
+// listItemValue extracts the kind-appropriate value (IP or ASN, as a
+// string) out of a Cloudflare List item returned by the API.
+func listItemValue(item cloudflare.ListItem) (string, bool) {
+	if item.IP != nil {
+		return *item.IP, true
+	}
+	if item.ASN != nil {
+		return strconv.Itoa(*item.ASN), true
+	}
+	return "", false
+}
+
+// CollectLAPIStream translates a batch of new/expired CrowdSec decisions
+// into the pending Cloudflare List add/delete maps, keyed by routing key
+// (see actionRoutingKind), and draining any previously scheduled operation
+// for the same value so the latest decision always wins. Decisions whose
+// scope has no corresponding list kind (e.g. "Country") are logged and
+// skipped. scenarioActions routes a decision whose CrowdSec scenario
+// matches an override to its own routing key instead of the bare kind.
+func CollectLAPIStream(stream *models.DecisionsStreamResponse, deleteItemMap map[string]map[string]bool, addItemMap map[string]map[pendingItem]bool, cloudflareIDByValue map[string]map[string]string, scenarioActions map[string]string) {
+	for _, decision := range stream.Deleted {
+		if decision.Value == nil || decision.Scope == nil {
+			continue
+		}
+		kind, ok := scopeToListKind(*decision.Scope)
+		if !ok {
+			log.Debugf("no Cloudflare list kind for scope '%s', skipping decision", *decision.Scope)
+			continue
+		}
+		kind = actionRoutingKind(kind, decision.Scenario, scenarioActions)
+		value := *decision.Value
+		for item := range addItemMap[kind] {
+			if item.Value == value {
+				delete(addItemMap[kind], item)
+			}
+		}
+		if id, ok := cloudflareIDByValue[kind][value]; ok {
+			if deleteItemMap[kind] == nil {
+				deleteItemMap[kind] = make(map[string]bool)
+			}
+			deleteItemMap[kind][id] = true
+			delete(cloudflareIDByValue[kind], value)
+		}
+	}
+
+	for _, decision := range stream.New {
+		if decision.Value == nil || decision.Scope == nil {
+			continue
+		}
+		kind, ok := scopeToListKind(*decision.Scope)
+		if !ok {
+			log.Debugf("no Cloudflare list kind for scope '%s', skipping decision", *decision.Scope)
+			continue
+		}
+		kind = actionRoutingKind(kind, decision.Scenario, scenarioActions)
+		value := *decision.Value
+		if id, ok := cloudflareIDByValue[kind][value]; ok {
+			delete(deleteItemMap[kind], id)
+		}
+		if addItemMap[kind] == nil {
+			addItemMap[kind] = make(map[pendingItem]bool)
+		}
+		addItemMap[kind][pendingItem{Value: value, Comment: "banned by crowdsec"}] = true
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/crowdsecurity/crowdsec/pkg/models"
+)
+
+func TestScopeToListKind(t *testing.T) {
+	cases := []struct {
+		scope string
+		kind  string
+		ok    bool
+	}{
+		{scope: "Ip", kind: listKindIP, ok: true},
+		{scope: "Range", kind: listKindIP, ok: true},
+		{scope: "AS", kind: listKindASN, ok: true},
+		{scope: "Country", kind: "", ok: false},
+		{scope: "", kind: "", ok: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.scope, func(t *testing.T) {
+			kind, ok := scopeToListKind(tc.scope)
+			if kind != tc.kind || ok != tc.ok {
+				t.Errorf("scopeToListKind(%q) = (%q, %v), want (%q, %v)", tc.scope, kind, ok, tc.kind, tc.ok)
+			}
+		})
+	}
+}
+
+func TestListName(t *testing.T) {
+	if got := listName("crowdsec", listKindIP); got != "crowdsec" {
+		t.Errorf("listName for the ip kind = %q, want unmodified base name", got)
+	}
+	if got := listName("crowdsec", listKindASN); got != "crowdsec_asn" {
+		t.Errorf("listName for the asn kind = %q, want %q", got, "crowdsec_asn")
+	}
+}
+
+func TestNewListItemCreateRequest(t *testing.T) {
+	ipReq, err := newListItemCreateRequest(listKindIP, pendingItem{Value: "1.2.3.4", Comment: "banned"})
+	if err != nil {
+		t.Fatalf("unexpected error for ip kind: %s", err)
+	}
+	if ipReq.IP == nil || *ipReq.IP != "1.2.3.4" {
+		t.Errorf("ip request = %+v, want IP set to 1.2.3.4", ipReq)
+	}
+
+	asnReq, err := newListItemCreateRequest(listKindASN, pendingItem{Value: "64512", Comment: "banned"})
+	if err != nil {
+		t.Fatalf("unexpected error for asn kind: %s", err)
+	}
+	if asnReq.ASN == nil || *asnReq.ASN != 64512 {
+		t.Errorf("asn request = %+v, want ASN set to 64512", asnReq)
+	}
+
+	if _, err := newListItemCreateRequest(listKindASN, pendingItem{Value: "not-a-number"}); err == nil {
+		t.Error("expected an error for a non-numeric ASN value")
+	}
+
+	if _, err := newListItemCreateRequest(listKindHostname, pendingItem{Value: "example.com"}); err == nil {
+		t.Error("expected an error for a kind with no CrowdSec decision mapping")
+	}
+}
+
+func strPtr(s string) *string { return &s }
+
+func TestCollectLAPIStream(t *testing.T) {
+	deleteItemMap := map[string]map[string]bool{}
+	addItemMap := map[string]map[pendingItem]bool{}
+	cloudflareIDByValue := map[string]map[string]string{
+		listKindIP: {"1.2.3.4": "existing-id"},
+	}
+
+	stream := &models.DecisionsStreamResponse{
+		New: []*models.Decision{
+			{Value: strPtr("5.6.7.8"), Scope: strPtr("Ip")},
+			{Value: strPtr("country-scoped"), Scope: strPtr("Country")},
+		},
+	}
+	CollectLAPIStream(stream, deleteItemMap, addItemMap, cloudflareIDByValue, nil)
+
+	if !addItemMap[listKindIP][pendingItem{Value: "5.6.7.8", Comment: "banned by crowdsec"}] {
+		t.Error("expected 5.6.7.8 to be queued for addition")
+	}
+	for item := range addItemMap[listKindIP] {
+		if item.Value == "country-scoped" {
+			t.Error("a decision with an unsupported scope should not be queued")
+		}
+	}
+
+	// A deleted decision for a value Cloudflare already has should queue
+	// that value's known Cloudflare ID for deletion.
+	stream = &models.DecisionsStreamResponse{
+		Deleted: []*models.Decision{
+			{Value: strPtr("1.2.3.4"), Scope: strPtr("Ip")},
+		},
+	}
+	CollectLAPIStream(stream, deleteItemMap, addItemMap, cloudflareIDByValue, nil)
+
+	if !deleteItemMap[listKindIP]["existing-id"] {
+		t.Error("expected the known Cloudflare ID for 1.2.3.4 to be queued for deletion")
+	}
+	if _, stillPending := cloudflareIDByValue[listKindIP]["1.2.3.4"]; stillPending {
+		t.Error("expected 1.2.3.4 to be removed from cloudflareIDByValue once scheduled for deletion")
+	}
+
+	// A later "New" decision for the same value should cancel the
+	// pending delete instead of racing it.
+	stream = &models.DecisionsStreamResponse{
+		New: []*models.Decision{
+			{Value: strPtr("1.2.3.4"), Scope: strPtr("Ip")},
+		},
+	}
+	cloudflareIDByValue[listKindIP]["1.2.3.4"] = "existing-id"
+	deleteItemMap[listKindIP]["existing-id"] = true
+	CollectLAPIStream(stream, deleteItemMap, addItemMap, cloudflareIDByValue, nil)
+
+	if deleteItemMap[listKindIP]["existing-id"] {
+		t.Error("a fresh decision for the same value should cancel the pending delete")
+	}
+}
+
+func TestCollectLAPIStreamScenarioActions(t *testing.T) {
+	deleteItemMap := map[string]map[string]bool{}
+	addItemMap := map[string]map[pendingItem]bool{}
+	cloudflareIDByValue := map[string]map[string]string{}
+	scenarioActions := map[string]string{"crowdsecurity/http-probing": "challenge"}
+
+	stream := &models.DecisionsStreamResponse{
+		New: []*models.Decision{
+			{Value: strPtr("9.9.9.9"), Scope: strPtr("Ip"), Scenario: strPtr("crowdsecurity/http-probing")},
+			{Value: strPtr("1.1.1.1"), Scope: strPtr("Ip"), Scenario: strPtr("crowdsecurity/other-scenario")},
+		},
+	}
+	CollectLAPIStream(stream, deleteItemMap, addItemMap, cloudflareIDByValue, scenarioActions)
+
+	if !addItemMap["ip:challenge"][pendingItem{Value: "9.9.9.9", Comment: "banned by crowdsec"}] {
+		t.Error("expected the overridden scenario's decision to be queued under the ip:challenge routing key")
+	}
+	if !addItemMap[listKindIP][pendingItem{Value: "1.1.1.1", Comment: "banned by crowdsec"}] {
+		t.Error("expected the non-overridden scenario's decision to be queued under the bare ip routing key")
+	}
+}
+
+func TestBaseListKind(t *testing.T) {
+	if got := baseListKind("ip"); got != listKindIP {
+		t.Errorf("baseListKind(%q) = %q, want %q", "ip", got, listKindIP)
+	}
+	if got := baseListKind("ip:challenge"); got != listKindIP {
+		t.Errorf("baseListKind(%q) = %q, want %q", "ip:challenge", got, listKindIP)
+	}
+}
+
+func TestActionRoutingKind(t *testing.T) {
+	scenarioActions := map[string]string{"crowdsecurity/http-probing": "challenge"}
+
+	if got := actionRoutingKind(listKindIP, strPtr("crowdsecurity/http-probing"), scenarioActions); got != "ip:challenge" {
+		t.Errorf("actionRoutingKind with an overridden scenario = %q, want %q", got, "ip:challenge")
+	}
+	if got := actionRoutingKind(listKindIP, strPtr("crowdsecurity/other-scenario"), scenarioActions); got != listKindIP {
+		t.Errorf("actionRoutingKind with a non-overridden scenario = %q, want %q", got, listKindIP)
+	}
+	if got := actionRoutingKind(listKindIP, nil, scenarioActions); got != listKindIP {
+		t.Errorf("actionRoutingKind with a nil scenario = %q, want %q", got, listKindIP)
+	}
+}
+
+func TestDistinctSortedActions(t *testing.T) {
+	got := distinctSortedActions(map[string]string{
+		"crowdsecurity/http-probing": "challenge",
+		"crowdsecurity/ssh-bf":       "block",
+		"crowdsecurity/scan":         "challenge",
+	})
+	want := []string{"block", "challenge"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("distinctSortedActions = %v, want %v", got, want)
+	}
+}